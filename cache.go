@@ -37,6 +37,8 @@ import (
 	"strconv"
 	"strings"
 	"time"
+
+	"golang.org/x/sync/singleflight"
 )
 
 // Response is the cached response data structure.
@@ -57,17 +59,46 @@ type Response struct {
 	// Frequency is the count of times a cached response is accessed.
 	// Used for LFU and MFU algorithms.
 	Frequency int
+
+	// Date is the response's Date header value, used to compute the Age
+	// response header in RFC7234 mode.
+	Date time.Time
+
+	// Vary lists the header names the origin server varied the response
+	// on. Populated only in RFC7234 mode.
+	Vary []string
+
+	// VaryValues holds the request header values captured for each name
+	// in Vary, so a later request can be matched against this variant.
+	VaryValues map[string]string
+
+	// ETag is the response's ETag header value, used to revalidate a
+	// stale entry instead of re-fetching the full body.
+	ETag string
+
+	// LastModified is the response's Last-Modified header value, used
+	// as a fallback validator when ETag is absent.
+	LastModified string
 }
 
 // Client data structure for HTTP cache middleware.
 type Client struct {
-	adapter            Adapter
-	ttl                time.Duration
-	refreshKey         string
-	methods            []string
-	writeExpiresHeader bool
-	vary               []string
-	generateKey        GenerateKey
+	adapter              Adapter
+	ttl                  time.Duration
+	refreshKey           string
+	methods              []string
+	writeExpiresHeader   bool
+	vary                 []string
+	generateKey          GenerateKey
+	rfc7234              bool
+	fromCacheHeader      string
+	singleflightEnabled  bool
+	sfGroup              singleflight.Group
+	rules                []Rule
+	staleWhileRevalidate time.Duration
+	staleIfError         time.Duration
+	refreshGroup         singleflight.Group
+	codec                Codec
 }
 
 // ClientOption is used to set Client settings.
@@ -92,69 +123,296 @@ type GenerateKey func(*http.Request) []byte
 func (c *Client) Middleware(next http.Handler) http.Handler {
 	vary := strings.Join(c.vary, ",")
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if !c.cacheableMethod(r.Method) {
+		if !c.cacheableMethod(r.Method) || !c.cacheableRequest(r) {
 			next.ServeHTTP(w, r)
 			return
 		}
 
+		var reqDirectives requestDirectives
+		if c.rfc7234 {
+			reqDirectives = parseRequestDirectives(r.Header)
+			if reqDirectives.noStore {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+
 		sortURLParams(r.URL)
-		key := c.hash(r)
+		baseKey := c.hash(r)
+		key := baseKey
 
 		params := r.URL.Query()
 		if _, ok := params[c.refreshKey]; ok {
 			delete(params, c.refreshKey)
 
 			r.URL.RawQuery = params.Encode()
-			key = c.hash(r)
-
+			baseKey = c.hash(r)
+			key = baseKey
+
+			if c.rfc7234 {
+				// The base key only ever carries a Vary signpost; also
+				// release the variant matching this request's own header
+				// values. Other variants of the same URL are left to
+				// expire on their own TTL: the adapter has no way to
+				// enumerate them from a single key.
+				if signpost, ok := c.lookup(key); ok && len(signpost.Vary) > 0 {
+					values := captureVaryValues(r.Header, signpost.Vary)
+					c.adapter.Release(c.varyHash(key, signpost.Vary, values))
+				}
+			}
 			c.adapter.Release(key)
-		} else {
-			b, ok := c.adapter.Get(key)
-			response := BytesToResponse(b)
-			if ok {
-				if response.Expiration.After(time.Now()) {
+		} else if !c.rfc7234 || !reqDirectives.noCache {
+			response, ok := c.lookup(key)
+			if ok && c.rfc7234 && len(response.Vary) > 0 {
+				// The base key only ever points at a Vary-agnostic signpost;
+				// once its Vary names are known, look up the variant that
+				// actually matches this request's header values instead of
+				// colliding with whichever variant is stored at the base key.
+				values := captureVaryValues(r.Header, response.Vary)
+				if variantKey := c.varyHash(key, response.Vary, values); variantKey != key {
+					key = variantKey
+					response, ok = c.lookup(key)
+				}
+			}
+			if ok && varyMatches(response, r) {
+				fresh := response.Expiration.After(time.Now())
+				if c.rfc7234 {
+					fresh = reqDirectives.acceptable(time.Since(response.Date), time.Until(response.Expiration))
+				}
+				if fresh {
+					if notModified(response, r) {
+						c.setCachedHeaders(w, response, vary)
+						w.WriteHeader(http.StatusNotModified)
+						return
+					}
+
 					response.LastAccess = time.Now()
 					response.Frequency++
-					c.adapter.Set(key, response.Bytes(), response.Expiration)
+					c.setResponse(key, response)
 
-					//w.WriteHeader(http.StatusNotModified)
-					for k, v := range response.Header {
-						w.Header().Set(k, strings.Join(v, ","))
-					}
-					if c.writeExpiresHeader {
-						w.Header().Set("Expires", response.Expiration.UTC().Format(http.TimeFormat))
-					}
-					if vary != "" {
-						w.Header().Set("Vary", vary)
+					c.writeCached(w, response, vary)
+					return
+				}
+
+				swr, sie := c.staleWindows(response.Header)
+				staleAge := time.Since(response.Expiration)
+
+				if swr > 0 && staleAge <= swr {
+					c.writeCached(w, response, vary)
+					c.refreshStale(next, r, baseKey, key)
+					return
+				}
+
+				var rec *conditionalRecorder
+				if response.ETag != "" || response.LastModified != "" {
+					var revalidated bool
+					rec, revalidated = c.revalidate(next, r, response)
+					if revalidated {
+						response.Header = mergeHeader(response.Header, rec.header)
+						response.Expiration = time.Now().Add(c.ttl)
+						response.Date = responseDate(rec.header, time.Now())
+						response.LastAccess = time.Now()
+						response.Frequency++
+						if c.rfc7234 {
+							response.Expiration = time.Now().Add(freshnessLifetime(rec.header, c.ttl))
+						}
+						c.setResponse(key, response)
+						if len(response.Vary) > 0 && baseKey != key {
+							// Keep the base-key signpost's expiration in
+							// step with the variant it points at, so it
+							// doesn't outlive or (worse) expire before the
+							// revalidated entry it was pointing to.
+							c.setVarySignpost(baseKey, response.Vary, response.Expiration)
+						}
+
+						c.writeCached(w, response, vary)
+						return
 					}
-					w.Write(response.Value)
+				} else {
+					rec = newConditionalRecorder()
+					next.ServeHTTP(rec, r)
+				}
+
+				if rec.statusCode >= 500 && sie > 0 && staleAge <= sie {
+					c.writeCached(w, response, vary)
 					return
 				}
 
-				c.adapter.Release(key)
+				// Origin sent a usable representation: serve and store it
+				// directly, without invoking next a second time.
+				c.store(r, baseKey, rec.header, rec.statusCode, rec.body)
+				c.writeResponse(w, rec.statusCode, rec.header, rec.body)
+				return
 			}
 		}
 
-		rw := &responseWriter{ResponseWriter: w}
-		next.ServeHTTP(rw, r)
-
-		statusCode := rw.statusCode
-		value := rw.body
-		now := time.Now()
-		expires := now.Add(c.ttl)
-		if statusCode < 400 {
-			response := Response{
-				Value:      value,
-				Header:     rw.Header(),
-				Expiration: expires,
-				LastAccess: now,
-				Frequency:  1,
+		if c.singleflightEnabled {
+			v, _, _ := c.sfGroup.Do(KeyAsString(key), func() (interface{}, error) {
+				leader := newResponseWriter(w)
+				next.ServeHTTP(leader, r)
+				// Snapshot the header here, inside the single goroutine
+				// that owns the real ResponseWriter: net/http's Header()
+				// can lazily mutate internal state on first call after
+				// WriteHeader, so letting every waiter call through to it
+				// concurrently below would race.
+				leader.header = leader.Header().Clone()
+				if c.rfc7234 {
+					// Capture the leader's own request values for the
+					// headers its response varies on, so a follower whose
+					// request wants a different variant can be detected
+					// below instead of being served the leader's body.
+					leader.varyNames = splitHeaderList(leader.header.Get("Vary"))
+					leader.varyValues = captureVaryValues(r.Header, leader.varyNames)
+				}
+				return leader, nil
+			})
+			result := v.(*responseWriter)
+			if result.ResponseWriter == w {
+				// We ran next ourselves as leader: persist the entry.
+				c.store(r, baseKey, result.header, result.statusCode, result.body)
+				return
 			}
-			c.adapter.Set(key, response.Bytes(), response.Expiration)
+			if varyMismatch(result.varyNames, result.varyValues, r) {
+				// The coalesced response belongs to a different variant
+				// than the one this request wants: fetch and store our
+				// own instead of serving the leader's mismatched body.
+				rw := newResponseWriter(w)
+				next.ServeHTTP(rw, r)
+				c.store(r, baseKey, rw.Header(), rw.statusCode, rw.body)
+				return
+			}
+			// A concurrent request for the same key already ran next
+			// and already stored the entry; replay its captured
+			// response into our own writer.
+			c.writeResponse(w, result.statusCode, result.header, result.body)
+			return
 		}
+
+		rw := newResponseWriter(w)
+		next.ServeHTTP(rw, r)
+
+		c.store(r, baseKey, rw.Header(), rw.statusCode, rw.body)
 	})
 }
 
+// store builds the cache entry for a freshly produced response and, if it
+// is storable, persists it via the adapter. baseKey is the Vary-agnostic
+// key the caller already computed via c.hash(r); when the response carries
+// a Vary header, the request's values for those header names are folded
+// into baseKey (see varyHash) to get the actual storage key, so each
+// variant (e.g. Accept-Encoding: gzip vs br) gets its own entry instead of
+// evicting whichever variant was stored last. A signpost is also left at
+// baseKey, so a later request for a different variant can discover this
+// entry's Vary names and compute its own variant key.
+func (c *Client) store(r *http.Request, baseKey uint64, header http.Header, statusCode int, value []byte) {
+	now := time.Now()
+
+	storable := c.cacheableResponse(statusCode, header, value)
+	if !hasStatusRule(c.rules) {
+		// Unless a StatusRule has taken explicit control of which status
+		// codes are storable, keep the historical default: only
+		// successful/redirect responses are cacheable.
+		storable = storable && statusCode < 400
+	}
+	expires := now.Add(c.ttl)
+	date := now
+	key := baseKey
+	var varyNames []string
+	var varyValues map[string]string
+	if c.rfc7234 {
+		storable = storable && isStorable(header, statusCode)
+		date = responseDate(header, now)
+		expires = now.Add(freshnessLifetime(header, c.ttl))
+		if varyHeader := header.Get("Vary"); varyHeader != "" {
+			varyNames = splitHeaderList(varyHeader)
+			varyValues = captureVaryValues(r.Header, varyNames)
+			key = c.varyHash(baseKey, varyNames, varyValues)
+		}
+	}
+
+	if !storable {
+		return
+	}
+
+	response := Response{
+		Value:        value,
+		Header:       header,
+		Expiration:   expires,
+		LastAccess:   now,
+		Frequency:    1,
+		Date:         date,
+		Vary:         varyNames,
+		VaryValues:   varyValues,
+		ETag:         header.Get("ETag"),
+		LastModified: header.Get("Last-Modified"),
+	}
+	c.setResponse(key, response)
+	if key != baseKey {
+		c.setVarySignpost(baseKey, varyNames, expires)
+	}
+}
+
+// setResponse serializes response with the configured codec and persists
+// it. A marshal error is treated as non-fatal: the entry is simply not
+// stored rather than failing the request.
+func (c *Client) setResponse(key uint64, response Response) {
+	b, err := c.codec.Marshal(response)
+	if err != nil {
+		return
+	}
+	c.adapter.Set(key, b, response.Expiration)
+}
+
+// setVarySignpost persists a marker at the Vary-agnostic base key, carrying
+// just the Vary names and an expiration, so a later request for a
+// different variant can discover them and compute its own variant key
+// without paying for a duplicate body. The signpost's expiration is kept
+// as the latest of any variant seen so far, so storing a short-TTL variant
+// never shortens the window in which a still-valid long-TTL variant can be
+// found.
+func (c *Client) setVarySignpost(baseKey uint64, varyNames []string, expires time.Time) {
+	if existing, ok := c.lookup(baseKey); ok && existing.Expiration.After(expires) {
+		expires = existing.Expiration
+	}
+	c.setResponse(baseKey, Response{Vary: varyNames, Expiration: expires})
+}
+
+// setCachedHeaders sets the header set the middleware uses for any cache
+// hit, whether served with a body or as a bare 304: the stored headers,
+// Expires/Vary/Age as configured, and the from-cache marker.
+func (c *Client) setCachedHeaders(w http.ResponseWriter, response Response, vary string) {
+	for k, v := range response.Header {
+		w.Header().Set(k, strings.Join(v, ","))
+	}
+	if c.writeExpiresHeader {
+		w.Header().Set("Expires", response.Expiration.UTC().Format(http.TimeFormat))
+	}
+	if vary != "" {
+		w.Header().Set("Vary", vary)
+	}
+	if c.rfc7234 {
+		w.Header().Set("Age", strconv.Itoa(int(time.Since(response.Date).Seconds())))
+	}
+	w.Header().Set(c.fromCacheHeader, "1")
+}
+
+// writeCached writes a stored response to w, the way the middleware does
+// for a cache hit: the cached header set, then the body.
+func (c *Client) writeCached(w http.ResponseWriter, response Response, vary string) {
+	c.setCachedHeaders(w, response, vary)
+	w.Write(response.Value)
+}
+
+// writeResponse writes a freshly produced response (header, status code and
+// body) to w.
+func (c *Client) writeResponse(w http.ResponseWriter, statusCode int, header http.Header, body []byte) {
+	for k, v := range header {
+		w.Header()[k] = v
+	}
+	w.WriteHeader(statusCode)
+	w.Write(body)
+}
+
 func (c *Client) cacheableMethod(method string) bool {
 	for _, m := range c.methods {
 		if method == m {
@@ -164,6 +422,28 @@ func (c *Client) cacheableMethod(method string) bool {
 	return false
 }
 
+// cacheableRequest reports whether every configured Rule allows r to be
+// looked up or stored at all.
+func (c *Client) cacheableRequest(r *http.Request) bool {
+	for _, rule := range c.rules {
+		if !rule.MatchRequest(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// cacheableResponse reports whether every configured Rule allows a freshly
+// produced response to be persisted.
+func (c *Client) cacheableResponse(statusCode int, header http.Header, body []byte) bool {
+	for _, rule := range c.rules {
+		if !rule.MatchResponse(statusCode, header, body) {
+			return false
+		}
+	}
+	return true
+}
+
 // BytesToResponse converts bytes array into Response data structure.
 func BytesToResponse(b []byte) Response {
 	var r Response
@@ -208,6 +488,57 @@ func (c *Client) hash(r *http.Request) uint64 {
 	return hash.Sum64()
 }
 
+// varyHash refines a base key with the request's values for a set of
+// Vary-listed header names, so each combination of values gets its own
+// cache entry instead of colliding with every other variant. Returns key
+// unchanged when names is empty.
+func (c *Client) varyHash(key uint64, names []string, values map[string]string) uint64 {
+	if len(names) == 0 {
+		return key
+	}
+	sorted := append([]string(nil), names...)
+	sort.Strings(sorted)
+	hash := fnv.New64a()
+	hash.Write([]byte(strconv.FormatUint(key, 36)))
+	for _, name := range sorted {
+		hash.Write([]byte(name))
+		hash.Write([]byte{0})
+		hash.Write([]byte(values[name]))
+		hash.Write([]byte{0})
+	}
+	return hash.Sum64()
+}
+
+// varyMismatch reports whether r's own values for a singleflight leader's
+// Vary header names differ from the values the leader captured, meaning
+// the leader's response is the wrong variant for r. Always false when the
+// leader's response didn't vary on anything. A leader response carrying
+// Vary: * is never reusable for any other request, per RFC 7234 section
+// 4.1 (mirroring the read-path guard in varyMatches).
+func varyMismatch(varyNames []string, varyValues map[string]string, r *http.Request) bool {
+	for _, name := range varyNames {
+		if name == "*" {
+			return true
+		}
+		if r.Header.Get(name) != varyValues[name] {
+			return true
+		}
+	}
+	return false
+}
+
+// lookup fetches and decodes the entry at key, treating a corrupt entry as
+// a miss (and releasing it) rather than serving garbage.
+func (c *Client) lookup(key uint64) (Response, bool) {
+	b, ok := c.adapter.Get(key)
+	response, err := c.codec.Unmarshal(b)
+	if ok && err != nil {
+		c.adapter.Release(key)
+		return Response{}, false
+	}
+	return response, ok
+}
+
 func DefaultGenerateKey(r *http.Request) []byte {
 	if r.Method == http.MethodPost && r.Body != nil {
 		body, err := io.ReadAll(r.Body)
@@ -243,6 +574,12 @@ func NewClient(opts ...ClientOption) (*Client, error) {
 	if c.generateKey == nil {
 		c.generateKey = DefaultGenerateKey
 	}
+	if c.fromCacheHeader == "" {
+		c.fromCacheHeader = "X-From-Cache"
+	}
+	if c.codec == nil {
+		c.codec = GobCodec{}
+	}
 
 	return c, nil
 }
@@ -316,10 +653,74 @@ func ClientWithExpiresHeader() ClientOption {
 	}
 }
 
+// ClientWithFromCacheHeaderName sets the name of the response header used to
+// flag a cache hit. Optional setting. If not set, default is "X-From-Cache".
+func ClientWithFromCacheHeaderName(name string) ClientOption {
+	return func(c *Client) error {
+		if name == "" {
+			return errors.New("cache client from-cache header name can't be empty")
+		}
+		c.fromCacheHeader = name
+		return nil
+	}
+}
+
+// ClientWithSingleflight coalesces concurrent cache misses for the same key:
+// only one waiting request actually invokes next, and the others block on
+// the shared result instead of stampeding the origin handler.
+func ClientWithSingleflight() ClientOption {
+	return func(c *Client) error {
+		c.singleflightEnabled = true
+		return nil
+	}
+}
+
+// ClientWithStaleWhileRevalidate sets the fallback grace period (RFC 5861)
+// during which an expired entry is still served immediately while a
+// background request refreshes it. A response's own
+// "stale-while-revalidate" Cache-Control directive takes precedence over
+// this value.
+func ClientWithStaleWhileRevalidate(d time.Duration) ClientOption {
+	return func(c *Client) error {
+		c.staleWhileRevalidate = d
+		return nil
+	}
+}
+
+// ClientWithStaleIfError sets the fallback grace period (RFC 5861) during
+// which an expired entry is served instead of a 5xx or transport error from
+// next. A response's own "stale-if-error" Cache-Control directive takes
+// precedence over this value.
+func ClientWithStaleIfError(d time.Duration) ClientOption {
+	return func(c *Client) error {
+		c.staleIfError = d
+		return nil
+	}
+}
+
 type responseWriter struct {
 	http.ResponseWriter
 	statusCode int
 	body       []byte
+	// header holds a snapshot of the response header taken once the
+	// handler has finished writing, for singleflight to hand to waiters
+	// without calling back into the live ResponseWriter. Unused outside
+	// the singleflight path.
+	header http.Header
+	// varyNames and varyValues capture, for the singleflight leader, the
+	// Vary header names its response carries and the leader's own
+	// request's values for them, so a follower wanting a different
+	// variant can be detected instead of replayed the leader's body.
+	// Unused outside the singleflight path.
+	varyNames  []string
+	varyValues map[string]string
+}
+
+func newResponseWriter(w http.ResponseWriter) *responseWriter {
+	// A handler that never calls WriteHeader implicitly sends 200, per
+	// net/http; default to that so a captured response replayed for a
+	// singleflight waiter doesn't carry a zero status code.
+	return &responseWriter{ResponseWriter: w, statusCode: http.StatusOK}
 }
 
 func (w *responseWriter) WriteHeader(statusCode int) {
@@ -328,6 +729,9 @@ func (w *responseWriter) WriteHeader(statusCode int) {
 }
 
 func (w *responseWriter) Write(b []byte) (int, error) {
-	w.body = b
+	// Handlers may call Write multiple times when streaming a response;
+	// append rather than overwrite so the captured body matches what the
+	// client actually received.
+	w.body = append(w.body, b...)
 	return w.ResponseWriter.Write(b)
 }
@@ -0,0 +1,457 @@
+package cache
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// memoryAdapter is a minimal Adapter backed by a map, enough to exercise
+// Middleware in tests without pulling in a real storage backend.
+type memoryAdapter struct {
+	mu      sync.Mutex
+	entries map[uint64][]byte
+}
+
+func newMemoryAdapter() *memoryAdapter {
+	return &memoryAdapter{entries: map[uint64][]byte{}}
+}
+
+func (a *memoryAdapter) Get(key uint64) ([]byte, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	b, ok := a.entries[key]
+	return b, ok
+}
+
+func (a *memoryAdapter) Set(key uint64, response []byte, expiration time.Time) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.entries[key] = response
+}
+
+func (a *memoryAdapter) Release(key uint64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.entries, key)
+}
+
+// TestMiddlewareSingleflightCoalescesConcurrentMisses fires a burst of
+// concurrent requests for the same uncached key at a slow handler and
+// asserts the handler only ever runs once, and that every caller still
+// gets back a valid 200 response instead of a panic from a zero status
+// code being replayed into a real ResponseWriter.
+func TestMiddlewareSingleflightCoalescesConcurrentMisses(t *testing.T) {
+	var calls atomic.Int32
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		time.Sleep(300 * time.Millisecond)
+		w.Write([]byte("ok"))
+	})
+
+	client, err := NewClient(
+		ClientWithAdapter(newMemoryAdapter()),
+		ClientWithTTL(time.Minute),
+		ClientWithSingleflight(),
+	)
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+
+	server := httptest.NewServer(client.Middleware(handler))
+	defer server.Close()
+
+	const concurrency = 100
+	var wg sync.WaitGroup
+	var ready sync.WaitGroup
+	start := make(chan struct{})
+	errs := make(chan error, concurrency)
+	ready.Add(concurrency)
+	wg.Add(concurrency)
+	for i := 0; i < concurrency; i++ {
+		go func() {
+			defer wg.Done()
+			ready.Done()
+			<-start
+			resp, err := http.Get(server.URL)
+			if err != nil {
+				errs <- err
+				return
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				errs <- fmt.Errorf("status = %d, want %d", resp.StatusCode, http.StatusOK)
+			}
+		}()
+	}
+	ready.Wait()
+	close(start)
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Errorf("request failed: %v", err)
+		}
+	}
+
+	if got := calls.Load(); got != 1 {
+		t.Errorf("handler invoked %d times, want 1", got)
+	}
+}
+
+// TestMiddlewareEchoes304Headers covers the direct (non-revalidating) 304
+// short-circuit: a request whose If-None-Match already matches the stored,
+// still-fresh entry must get the cached validators back, not a bare 304.
+func TestMiddlewareEchoes304Headers(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("ETag", `"v1"`)
+		w.Write([]byte(`{"ok":true}`))
+	})
+
+	client, err := NewClient(
+		ClientWithAdapter(newMemoryAdapter()),
+		ClientWithTTL(time.Minute),
+	)
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	mw := client.Middleware(handler)
+
+	r1, _ := http.NewRequest(http.MethodGet, "/", nil)
+	w1 := httptest.NewRecorder()
+	mw.ServeHTTP(w1, r1)
+	if w1.Code != http.StatusOK {
+		t.Fatalf("first request status = %d, want 200", w1.Code)
+	}
+
+	r2, _ := http.NewRequest(http.MethodGet, "/", nil)
+	r2.Header.Set("If-None-Match", `"v1"`)
+	w2 := httptest.NewRecorder()
+	mw.ServeHTTP(w2, r2)
+
+	if w2.Code != http.StatusNotModified {
+		t.Fatalf("second request status = %d, want 304", w2.Code)
+	}
+	if got := w2.Header().Get("ETag"); got != `"v1"` {
+		t.Errorf("304 ETag = %q, want %q", got, `"v1"`)
+	}
+	if got := w2.Header().Get("Content-Type"); got != "application/json" {
+		t.Errorf("304 Content-Type = %q, want it echoed from the cached entry", got)
+	}
+	if got := w2.Header().Get("X-From-Cache"); got != "1" {
+		t.Errorf("304 X-From-Cache = %q, want %q", got, "1")
+	}
+}
+
+// TestMiddlewareStoreKeepsErrorDefaultWithoutStatusRule reproduces the
+// reviewer's scenario: configuring an unrelated rule must not by itself
+// start caching error responses.
+func TestMiddlewareStoreKeepsErrorDefaultWithoutStatusRule(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("boom"))
+	})
+
+	adapter := newMemoryAdapter()
+	client, err := NewClient(
+		ClientWithAdapter(adapter),
+		ClientWithTTL(time.Minute),
+		ClientWithRules(MaxBodyBytesRule(1<<20)),
+	)
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+
+	r, _ := http.NewRequest(http.MethodGet, "/", nil)
+	w := httptest.NewRecorder()
+	client.Middleware(handler).ServeHTTP(w, r)
+
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("status = %d, want 500", w.Code)
+	}
+	if _, ok := adapter.Get(client.hash(r)); ok {
+		t.Error("500 response was cached despite no StatusRule opting in >=400 storage")
+	}
+}
+
+// TestMiddlewareMergesRevalidationHeaders covers the revalidate path: a
+// successful 304 from the origin must fold into the stored header set
+// instead of replacing it, so headers the 304 didn't repeat survive.
+func TestMiddlewareMergesRevalidationHeaders(t *testing.T) {
+	var calls atomic.Int32
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := calls.Add(1)
+		if n == 1 {
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("X-Custom", "keep-me")
+			w.Header().Set("ETag", `"v1"`)
+			w.Write([]byte(`{"ok":true}`))
+			return
+		}
+		// Revalidation request: origin confirms the entry is unchanged
+		// with a 304 that, as real origins do, only repeats ETag.
+		w.Header().Set("ETag", `"v1"`)
+		w.WriteHeader(http.StatusNotModified)
+	})
+
+	client, err := NewClient(
+		ClientWithAdapter(newMemoryAdapter()),
+		ClientWithTTL(time.Millisecond),
+	)
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	mw := client.Middleware(handler)
+
+	r1, _ := http.NewRequest(http.MethodGet, "/", nil)
+	w1 := httptest.NewRecorder()
+	mw.ServeHTTP(w1, r1)
+	if w1.Code != http.StatusOK {
+		t.Fatalf("first request status = %d, want 200", w1.Code)
+	}
+
+	time.Sleep(5 * time.Millisecond) // let the entry go stale
+
+	r2, _ := http.NewRequest(http.MethodGet, "/", nil)
+	w2 := httptest.NewRecorder()
+	mw.ServeHTTP(w2, r2)
+
+	if w2.Code != http.StatusOK {
+		t.Fatalf("second request status = %d, want 200", w2.Code)
+	}
+	if got := w2.Header().Get("Content-Type"); got != "application/json" {
+		t.Errorf("Content-Type = %q, want it preserved across revalidation", got)
+	}
+	if got := w2.Header().Get("X-Custom"); got != "keep-me" {
+		t.Errorf("X-Custom = %q, want it preserved across revalidation", got)
+	}
+	if got := w2.Body.String(); got != `{"ok":true}` {
+		t.Errorf("body = %q, want the cached body replayed", got)
+	}
+	if got := calls.Load(); got != 2 {
+		t.Errorf("handler invoked %d times, want 2 (initial miss + revalidation)", got)
+	}
+}
+
+// TestMiddlewareStaleWhileRevalidateDedupesBackgroundRefresh covers the
+// property most worth testing in refreshStale: an expired entry within the
+// stale-while-revalidate window is served immediately to every caller, and
+// a burst of concurrent requests against it triggers exactly one background
+// refresh rather than one per request.
+func TestMiddlewareStaleWhileRevalidateDedupesBackgroundRefresh(t *testing.T) {
+	var calls atomic.Int32
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			w.Write([]byte("v1"))
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+		w.Write([]byte("v2"))
+	})
+
+	client, err := NewClient(
+		ClientWithAdapter(newMemoryAdapter()),
+		ClientWithTTL(10*time.Millisecond),
+		ClientWithStaleWhileRevalidate(time.Second),
+	)
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+
+	server := httptest.NewServer(client.Middleware(handler))
+	defer server.Close()
+
+	resp1, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatalf("first request: %v", err)
+	}
+	body1, _ := io.ReadAll(resp1.Body)
+	resp1.Body.Close()
+	if string(body1) != "v1" {
+		t.Fatalf("first body = %q, want %q", body1, "v1")
+	}
+
+	time.Sleep(20 * time.Millisecond) // entry is now expired but within the SWR window
+
+	const concurrency = 20
+	var wg sync.WaitGroup
+	wg.Add(concurrency)
+	for i := 0; i < concurrency; i++ {
+		go func() {
+			defer wg.Done()
+			resp, err := http.Get(server.URL)
+			if err != nil {
+				t.Errorf("stale request: %v", err)
+				return
+			}
+			defer resp.Body.Close()
+			body, _ := io.ReadAll(resp.Body)
+			if string(body) != "v1" {
+				t.Errorf("stale body = %q, want %q (served immediately from cache)", body, "v1")
+			}
+		}()
+	}
+	wg.Wait()
+
+	time.Sleep(200 * time.Millisecond) // let the single background refresh finish
+
+	if got := calls.Load(); got != 2 {
+		t.Errorf("handler invoked %d times, want 2 (initial miss + one deduped background refresh)", got)
+	}
+}
+
+// TestMiddlewareStaleIfErrorServesStaleWithinGraceWindow covers the other
+// half of RFC 5861: a 5xx from next must fall back to the stale entry while
+// inside the stale-if-error window, but once the window has passed the
+// error is allowed through like any other miss.
+func TestMiddlewareStaleIfErrorServesStaleWithinGraceWindow(t *testing.T) {
+	var calls atomic.Int32
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			w.Write([]byte("v1"))
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+
+	client, err := NewClient(
+		ClientWithAdapter(newMemoryAdapter()),
+		ClientWithTTL(20*time.Millisecond),
+		ClientWithStaleIfError(40*time.Millisecond),
+	)
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	mw := client.Middleware(handler)
+
+	r1, _ := http.NewRequest(http.MethodGet, "/", nil)
+	w1 := httptest.NewRecorder()
+	mw.ServeHTTP(w1, r1)
+	if w1.Code != http.StatusOK || w1.Body.String() != "v1" {
+		t.Fatalf("first request = %d %q, want 200 %q", w1.Code, w1.Body.String(), "v1")
+	}
+
+	time.Sleep(30 * time.Millisecond) // stale, still within the 40ms stale-if-error window
+
+	r2, _ := http.NewRequest(http.MethodGet, "/", nil)
+	w2 := httptest.NewRecorder()
+	mw.ServeHTTP(w2, r2)
+	if w2.Code != http.StatusOK || w2.Body.String() != "v1" {
+		t.Fatalf("in-window request = %d %q, want the stale entry 200 %q", w2.Code, w2.Body.String(), "v1")
+	}
+
+	time.Sleep(40 * time.Millisecond) // past the stale-if-error window
+
+	r3, _ := http.NewRequest(http.MethodGet, "/", nil)
+	w3 := httptest.NewRecorder()
+	mw.ServeHTTP(w3, r3)
+	if w3.Code != http.StatusInternalServerError {
+		t.Errorf("out-of-window request status = %d, want 500 (grace window expired)", w3.Code)
+	}
+}
+
+// TestMiddlewareVaryVariantsCoexist reproduces the reviewer's scenario: a
+// response whose Vary lists a header must get one cache entry per value of
+// that header, so alternating variants don't evict each other. Accept-
+// Encoding gzip, then br, then gzip again should hit the origin exactly
+// twice (once per distinct variant), not once per request.
+func TestMiddlewareVaryVariantsCoexist(t *testing.T) {
+	var calls atomic.Int32
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.Header().Set("Vary", "Accept-Encoding")
+		w.Write([]byte(r.Header.Get("Accept-Encoding")))
+	})
+
+	client, err := NewClient(
+		ClientWithAdapter(newMemoryAdapter()),
+		ClientWithTTL(time.Minute),
+		ClientWithRFC7234(),
+	)
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	mw := client.Middleware(handler)
+
+	get := func(encoding string) *httptest.ResponseRecorder {
+		r, _ := http.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("Accept-Encoding", encoding)
+		w := httptest.NewRecorder()
+		mw.ServeHTTP(w, r)
+		return w
+	}
+
+	if got := get("gzip").Body.String(); got != "gzip" {
+		t.Fatalf("gzip body = %q, want %q", got, "gzip")
+	}
+	if got := get("br").Body.String(); got != "br" {
+		t.Fatalf("br body = %q, want %q", got, "br")
+	}
+	if got := get("gzip").Body.String(); got != "gzip" {
+		t.Errorf("repeated gzip body = %q, want %q (should be served from the gzip variant's own entry)", got, "gzip")
+	}
+
+	if got := calls.Load(); got != 2 {
+		t.Errorf("handler invoked %d times, want 2 (one per distinct variant, gzip re-served from cache)", got)
+	}
+}
+
+// TestMiddlewareSingleflightRespectsVaryVariants covers the singleflight
+// coalescing path specifically: two concurrent first-time requests for the
+// same URL but different Vary variants must not have one served the
+// other's body, even though they race into the same singleflight call.
+func TestMiddlewareSingleflightRespectsVaryVariants(t *testing.T) {
+	var calls atomic.Int32
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		time.Sleep(50 * time.Millisecond)
+		w.Header().Set("Vary", "Accept-Encoding")
+		w.Write([]byte(r.Header.Get("Accept-Encoding")))
+	})
+
+	client, err := NewClient(
+		ClientWithAdapter(newMemoryAdapter()),
+		ClientWithTTL(time.Minute),
+		ClientWithRFC7234(),
+		ClientWithSingleflight(),
+	)
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+
+	server := httptest.NewServer(client.Middleware(handler))
+	defer server.Close()
+
+	get := func(encoding string) (string, error) {
+		req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+		req.Header.Set("Accept-Encoding", encoding)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return string(body), nil
+	}
+
+	var wg sync.WaitGroup
+	results := make([]string, 2)
+	wg.Add(2)
+	go func() { defer wg.Done(); results[0], _ = get("gzip") }()
+	go func() { defer wg.Done(); results[1], _ = get("br") }()
+	wg.Wait()
+
+	if results[0] != "gzip" {
+		t.Errorf("gzip request body = %q, want %q (must not be served the br leader's body)", results[0], "gzip")
+	}
+	if results[1] != "br" {
+		t.Errorf("br request body = %q, want %q (must not be served the gzip leader's body)", results[1], "br")
+	}
+}
@@ -0,0 +1,208 @@
+package cache
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cacheControl is a parsed Cache-Control header. Directives without a
+// value (e.g. "no-cache") are stored with an empty string.
+type cacheControl map[string]string
+
+// parseCacheControl parses a Cache-Control header value into its directives.
+func parseCacheControl(header string) cacheControl {
+	cc := cacheControl{}
+	for _, part := range strings.Split(header, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		if i := strings.IndexByte(part, '='); i >= 0 {
+			cc[strings.ToLower(part[:i])] = strings.Trim(part[i+1:], `"`)
+		} else {
+			cc[strings.ToLower(part)] = ""
+		}
+	}
+	return cc
+}
+
+func (cc cacheControl) has(directive string) bool {
+	_, ok := cc[directive]
+	return ok
+}
+
+func (cc cacheControl) duration(directive string) (time.Duration, bool) {
+	v, ok := cc[directive]
+	if !ok {
+		return 0, false
+	}
+	seconds, err := strconv.Atoi(v)
+	if err != nil || seconds < 0 {
+		return 0, false
+	}
+	return time.Duration(seconds) * time.Second, true
+}
+
+// isStorable reports whether a response with the given header and status
+// code may be kept by a shared cache, per RFC 7234 section 3.
+func isStorable(header http.Header, statusCode int) bool {
+	cc := parseCacheControl(header.Get("Cache-Control"))
+	if cc.has("no-store") || cc.has("private") {
+		return false
+	}
+	if header.Get("Set-Cookie") != "" && !cc.has("public") {
+		return false
+	}
+	return true
+}
+
+// freshnessLifetime computes how long a response may be served from cache
+// without revalidation, following the precedence order from RFC 7234
+// section 4.2.1: s-maxage, then max-age, then Expires-Date, falling back
+// to ttl when none of the above is present.
+func freshnessLifetime(header http.Header, ttl time.Duration) time.Duration {
+	cc := parseCacheControl(header.Get("Cache-Control"))
+	if d, ok := cc.duration("s-maxage"); ok {
+		return d
+	}
+	if d, ok := cc.duration("max-age"); ok {
+		return d
+	}
+	if expires := header.Get("Expires"); expires != "" {
+		exp, err := http.ParseTime(expires)
+		if err != nil {
+			return ttl
+		}
+		date := time.Now()
+		if d := header.Get("Date"); d != "" {
+			if parsed, err := http.ParseTime(d); err == nil {
+				date = parsed
+			}
+		}
+		if lifetime := exp.Sub(date); lifetime > 0 {
+			return lifetime
+		}
+		return 0
+	}
+	return ttl
+}
+
+// responseDate returns the response's Date header, or now when it is
+// absent or unparsable.
+func responseDate(header http.Header, now time.Time) time.Time {
+	if d := header.Get("Date"); d != "" {
+		if parsed, err := http.ParseTime(d); err == nil {
+			return parsed
+		}
+	}
+	return now
+}
+
+// requestDirectives is the parsed Cache-Control (and Pragma) header of an
+// incoming request.
+type requestDirectives struct {
+	noCache     bool
+	noStore     bool
+	maxAge      time.Duration
+	hasMaxAge   bool
+	minFresh    time.Duration
+	maxStale    time.Duration
+	hasMaxStale bool
+}
+
+func parseRequestDirectives(header http.Header) requestDirectives {
+	cc := parseCacheControl(header.Get("Cache-Control"))
+	var rd requestDirectives
+	rd.noCache = cc.has("no-cache") || header.Get("Pragma") == "no-cache"
+	rd.noStore = cc.has("no-store")
+	rd.maxAge, rd.hasMaxAge = cc.duration("max-age")
+	rd.minFresh, _ = cc.duration("min-fresh")
+	rd.maxStale, rd.hasMaxStale = cc.duration("max-stale")
+	return rd
+}
+
+// acceptable reports whether a stored response, given its current age and
+// remaining freshness lifetime, satisfies the request's directives.
+func (rd requestDirectives) acceptable(age, remaining time.Duration) bool {
+	if rd.noCache {
+		return false
+	}
+	if rd.hasMaxAge && age > rd.maxAge {
+		return false
+	}
+	if remaining < 0 {
+		return rd.hasMaxStale && -remaining <= rd.maxStale
+	}
+	if rd.minFresh > 0 && remaining < rd.minFresh {
+		return false
+	}
+	return true
+}
+
+// splitHeaderList splits a comma-separated header value (e.g. Vary) into
+// its trimmed components.
+func splitHeaderList(v string) []string {
+	parts := strings.Split(v, ",")
+	names := make([]string, 0, len(parts))
+	for _, p := range parts {
+		if p = strings.TrimSpace(p); p != "" {
+			names = append(names, p)
+		}
+	}
+	return names
+}
+
+// captureVaryValues snapshots the request header values named by names, so
+// a later request can be matched against the same variant.
+func captureVaryValues(header http.Header, names []string) map[string]string {
+	values := make(map[string]string, len(names))
+	for _, name := range names {
+		values[name] = header.Get(name)
+	}
+	return values
+}
+
+// varyMatches reports whether the request carries the same values for the
+// response's Vary headers as the ones captured when the entry was stored.
+// A stored Vary: * is never reusable, per RFC 7234 section 4.1.
+func varyMatches(response Response, r *http.Request) bool {
+	for _, name := range response.Vary {
+		if name == "*" {
+			return false
+		}
+		if r.Header.Get(name) != response.VaryValues[name] {
+			return false
+		}
+	}
+	return true
+}
+
+// mergeHeader copies src into dst, overwriting any names src carries and
+// leaving the rest of dst untouched. Used to fold a 304 revalidation
+// response's (sparse) headers into the stored representation's full set.
+func mergeHeader(dst, src http.Header) http.Header {
+	merged := make(http.Header, len(dst)+len(src))
+	for k, v := range dst {
+		merged[k] = v
+	}
+	for k, v := range src {
+		merged[k] = v
+	}
+	return merged
+}
+
+// ClientWithRFC7234 makes the middleware behave like an RFC 7234 compliant
+// shared cache: response freshness is derived from Cache-Control/Expires
+// instead of a single fixed ttl, no-store/private/Set-Cookie responses are
+// refused, Vary is honored per entry, and request directives such as
+// no-cache, no-store, max-age, min-fresh and max-stale are applied. The
+// configured ttl is kept as the fallback lifetime when a response carries
+// no freshness information of its own.
+func ClientWithRFC7234() ClientOption {
+	return func(c *Client) error {
+		c.rfc7234 = true
+		return nil
+	}
+}
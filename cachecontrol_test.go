@@ -0,0 +1,187 @@
+package cache
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestParseCacheControl(t *testing.T) {
+	cc := parseCacheControl(`no-cache, max-age=60, s-maxage="120"`)
+	if !cc.has("no-cache") {
+		t.Error("expected no-cache directive")
+	}
+	if d, ok := cc.duration("max-age"); !ok || d != 60*time.Second {
+		t.Errorf("max-age = %v, %v, want 60s, true", d, ok)
+	}
+	if d, ok := cc.duration("s-maxage"); !ok || d != 120*time.Second {
+		t.Errorf("s-maxage = %v, %v, want 120s, true", d, ok)
+	}
+	if cc.has("private") {
+		t.Error("did not expect private directive")
+	}
+}
+
+func TestIsStorable(t *testing.T) {
+	tests := []struct {
+		name   string
+		header http.Header
+		want   bool
+	}{
+		{"plain response", http.Header{}, true},
+		{"no-store", http.Header{"Cache-Control": {"no-store"}}, false},
+		{"private", http.Header{"Cache-Control": {"private"}}, false},
+		{"set-cookie without public", http.Header{"Set-Cookie": {"a=b"}}, false},
+		{"set-cookie with public", http.Header{
+			"Cache-Control": {"public"}, "Set-Cookie": {"a=b"},
+		}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isStorable(tt.header, http.StatusOK); got != tt.want {
+				t.Errorf("isStorable() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFreshnessLifetime(t *testing.T) {
+	const ttl = 30 * time.Second
+
+	t.Run("s-maxage takes precedence", func(t *testing.T) {
+		header := http.Header{"Cache-Control": {"s-maxage=10, max-age=20"}}
+		if got := freshnessLifetime(header, ttl); got != 10*time.Second {
+			t.Errorf("freshnessLifetime() = %v, want 10s", got)
+		}
+	})
+
+	t.Run("max-age without s-maxage", func(t *testing.T) {
+		header := http.Header{"Cache-Control": {"max-age=20"}}
+		if got := freshnessLifetime(header, ttl); got != 20*time.Second {
+			t.Errorf("freshnessLifetime() = %v, want 20s", got)
+		}
+	})
+
+	t.Run("Expires falls back when no max-age", func(t *testing.T) {
+		now := time.Now().UTC().Truncate(time.Second)
+		header := http.Header{
+			"Date":    {now.Format(http.TimeFormat)},
+			"Expires": {now.Add(15 * time.Second).Format(http.TimeFormat)},
+		}
+		if got := freshnessLifetime(header, ttl); got != 15*time.Second {
+			t.Errorf("freshnessLifetime() = %v, want 15s", got)
+		}
+	})
+
+	t.Run("no freshness information falls back to ttl", func(t *testing.T) {
+		if got := freshnessLifetime(http.Header{}, ttl); got != ttl {
+			t.Errorf("freshnessLifetime() = %v, want %v", got, ttl)
+		}
+	})
+}
+
+func TestParseRequestDirectives(t *testing.T) {
+	header := http.Header{"Cache-Control": {"no-cache, max-age=5, min-fresh=2, max-stale=3"}}
+	rd := parseRequestDirectives(header)
+	if !rd.noCache {
+		t.Error("expected noCache")
+	}
+	if !rd.hasMaxAge || rd.maxAge != 5*time.Second {
+		t.Errorf("maxAge = %v, %v, want 5s, true", rd.maxAge, rd.hasMaxAge)
+	}
+	if rd.minFresh != 2*time.Second {
+		t.Errorf("minFresh = %v, want 2s", rd.minFresh)
+	}
+	if !rd.hasMaxStale || rd.maxStale != 3*time.Second {
+		t.Errorf("maxStale = %v, %v, want 3s, true", rd.maxStale, rd.hasMaxStale)
+	}
+
+	t.Run("Pragma no-cache", func(t *testing.T) {
+		rd := parseRequestDirectives(http.Header{"Pragma": {"no-cache"}})
+		if !rd.noCache {
+			t.Error("expected noCache from Pragma")
+		}
+	})
+
+	t.Run("no-store", func(t *testing.T) {
+		rd := parseRequestDirectives(http.Header{"Cache-Control": {"no-store"}})
+		if !rd.noStore {
+			t.Error("expected noStore")
+		}
+	})
+}
+
+func TestRequestDirectivesAcceptable(t *testing.T) {
+	tests := []struct {
+		name      string
+		rd        requestDirectives
+		age       time.Duration
+		remaining time.Duration
+		want      bool
+	}{
+		{"no-cache always rejects", requestDirectives{noCache: true}, 0, time.Minute, false},
+		{"fresh entry within max-age", requestDirectives{hasMaxAge: true, maxAge: 10 * time.Second}, 5 * time.Second, time.Minute, true},
+		{"entry older than max-age", requestDirectives{hasMaxAge: true, maxAge: 10 * time.Second}, 20 * time.Second, time.Minute, false},
+		{"stale entry beyond max-stale", requestDirectives{}, 0, -10 * time.Second, false},
+		{"stale entry within max-stale", requestDirectives{hasMaxStale: true, maxStale: 30 * time.Second}, 0, -10 * time.Second, true},
+		{"not fresh enough for min-fresh", requestDirectives{minFresh: time.Minute}, 0, 30 * time.Second, false},
+		{"fresh enough for min-fresh", requestDirectives{minFresh: 10 * time.Second}, 0, 30 * time.Second, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.rd.acceptable(tt.age, tt.remaining); got != tt.want {
+				t.Errorf("acceptable() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVaryMatches(t *testing.T) {
+	response := Response{
+		Vary:       []string{"Accept-Encoding"},
+		VaryValues: map[string]string{"Accept-Encoding": "gzip"},
+	}
+	r, _ := http.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	if !varyMatches(response, r) {
+		t.Error("expected matching Vary values to match")
+	}
+
+	r.Header.Set("Accept-Encoding", "br")
+	if varyMatches(response, r) {
+		t.Error("expected differing Vary values to not match")
+	}
+}
+
+func TestVaryMatchesRejectsWildcard(t *testing.T) {
+	response := Response{Vary: []string{"*"}}
+	r, _ := http.NewRequest(http.MethodGet, "/", nil)
+	if varyMatches(response, r) {
+		t.Error("expected Vary: * to never match")
+	}
+}
+
+func TestMergeHeader(t *testing.T) {
+	dst := http.Header{
+		"Content-Type": {"application/json"},
+		"X-Custom":     {"keep-me"},
+		"Etag":         {`"old"`},
+	}
+	src := http.Header{"Etag": {`"new"`}}
+
+	merged := mergeHeader(dst, src)
+
+	if got := merged.Get("Content-Type"); got != "application/json" {
+		t.Errorf("Content-Type = %q, want it preserved from dst", got)
+	}
+	if got := merged.Get("X-Custom"); got != "keep-me" {
+		t.Errorf("X-Custom = %q, want it preserved from dst", got)
+	}
+	if got := merged.Get("ETag"); got != `"new"` {
+		t.Errorf("ETag = %q, want overwritten by src", got)
+	}
+	// dst must not be mutated in place.
+	if got := dst.Get("ETag"); got != `"old"` {
+		t.Errorf("dst.ETag = %q, want dst left untouched", got)
+	}
+}
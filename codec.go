@@ -0,0 +1,294 @@
+package cache
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+)
+
+// Codec marshals and unmarshals a Response to and from bytes for storage
+// in an Adapter. Unlike the gob-based Response.Bytes/BytesToResponse, a
+// Codec can target a cross-language format (JSONCodec) or trade
+// flexibility for size and speed (BinaryCodec).
+type Codec interface {
+	Marshal(Response) ([]byte, error)
+	Unmarshal([]byte) (Response, error)
+}
+
+// ClientWithCodec sets the codec used to serialize cached responses.
+// Optional setting. If not set, default is GobCodec, matching
+// Response.Bytes/BytesToResponse for backward compatibility.
+func ClientWithCodec(codec Codec) ClientOption {
+	return func(c *Client) error {
+		if codec == nil {
+			return errors.New("cache client codec can't be nil")
+		}
+		c.codec = codec
+		return nil
+	}
+}
+
+// GobCodec serializes responses with encoding/gob. It is the default codec
+// and matches Response.Bytes/BytesToResponse exactly.
+type GobCodec struct{}
+
+// Marshal implements Codec.
+func (GobCodec) Marshal(r Response) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(&r); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Unmarshal implements Codec.
+func (GobCodec) Unmarshal(b []byte) (Response, error) {
+	var r Response
+	if err := gob.NewDecoder(bytes.NewReader(b)).Decode(&r); err != nil {
+		return Response{}, err
+	}
+	return r, nil
+}
+
+// JSONCodec serializes responses with encoding/json, so a cache populated
+// by a non-Go writer can still be read back.
+type JSONCodec struct{}
+
+// Marshal implements Codec.
+func (JSONCodec) Marshal(r Response) ([]byte, error) {
+	return json.Marshal(r)
+}
+
+// Unmarshal implements Codec.
+func (JSONCodec) Unmarshal(b []byte) (Response, error) {
+	var r Response
+	if err := json.Unmarshal(b, &r); err != nil {
+		return Response{}, err
+	}
+	return r, nil
+}
+
+// BinaryCodec serializes responses into a compact, length-prefixed binary
+// format: smaller and faster to encode/decode than gob or JSON.
+type BinaryCodec struct{}
+
+// Marshal implements Codec.
+func (BinaryCodec) Marshal(r Response) ([]byte, error) {
+	var buf bytes.Buffer
+	writeBytesField(&buf, r.Value)
+	writeHeaderField(&buf, r.Header)
+	writeTimeField(&buf, r.Expiration)
+	writeTimeField(&buf, r.LastAccess)
+	writeInt64Field(&buf, int64(r.Frequency))
+	writeTimeField(&buf, r.Date)
+	writeStringsField(&buf, r.Vary)
+	writeStringMapField(&buf, r.VaryValues)
+	writeStringField(&buf, r.ETag)
+	writeStringField(&buf, r.LastModified)
+	return buf.Bytes(), nil
+}
+
+// Unmarshal implements Codec.
+func (BinaryCodec) Unmarshal(b []byte) (Response, error) {
+	buf := bytes.NewReader(b)
+
+	var r Response
+	var err error
+	if r.Value, err = readBytesField(buf); err != nil {
+		return Response{}, err
+	}
+	if r.Header, err = readHeaderField(buf); err != nil {
+		return Response{}, err
+	}
+	if r.Expiration, err = readTimeField(buf); err != nil {
+		return Response{}, err
+	}
+	if r.LastAccess, err = readTimeField(buf); err != nil {
+		return Response{}, err
+	}
+	frequency, err := readInt64Field(buf)
+	if err != nil {
+		return Response{}, err
+	}
+	r.Frequency = int(frequency)
+	if r.Date, err = readTimeField(buf); err != nil {
+		return Response{}, err
+	}
+	if r.Vary, err = readStringsField(buf); err != nil {
+		return Response{}, err
+	}
+	if r.VaryValues, err = readStringMapField(buf); err != nil {
+		return Response{}, err
+	}
+	if r.ETag, err = readStringField(buf); err != nil {
+		return Response{}, err
+	}
+	if r.LastModified, err = readStringField(buf); err != nil {
+		return Response{}, err
+	}
+	return r, nil
+}
+
+func writeInt64Field(buf *bytes.Buffer, v int64) {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], uint64(v))
+	buf.Write(b[:])
+}
+
+func readInt64Field(r *bytes.Reader) (int64, error) {
+	var b [8]byte
+	if _, err := io.ReadFull(r, b[:]); err != nil {
+		return 0, err
+	}
+	return int64(binary.BigEndian.Uint64(b[:])), nil
+}
+
+func writeTimeField(buf *bytes.Buffer, t time.Time) {
+	writeInt64Field(buf, t.UnixNano())
+}
+
+func readTimeField(r *bytes.Reader) (time.Time, error) {
+	nsec, err := readInt64Field(r)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if nsec == 0 {
+		return time.Time{}, nil
+	}
+	return time.Unix(0, nsec).UTC(), nil
+}
+
+func writeBytesField(buf *bytes.Buffer, b []byte) {
+	writeInt64Field(buf, int64(len(b)))
+	buf.Write(b)
+}
+
+func readBytesField(r *bytes.Reader) ([]byte, error) {
+	n, err := readLength(r)
+	if err != nil {
+		return nil, err
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// readLength reads a length prefix and validates it against the bytes
+// actually remaining in r, so a negative or garbage value from a corrupt
+// or foreign-encoded entry returns an error instead of panicking on
+// make() or triggering an oversized allocation.
+func readLength(r *bytes.Reader) (int64, error) {
+	n, err := readInt64Field(r)
+	if err != nil {
+		return 0, err
+	}
+	if n < 0 || n > int64(r.Len()) {
+		return 0, errors.New("cache: corrupt binary-encoded entry")
+	}
+	return n, nil
+}
+
+func writeStringField(buf *bytes.Buffer, s string) {
+	writeBytesField(buf, []byte(s))
+}
+
+func readStringField(r *bytes.Reader) (string, error) {
+	b, err := readBytesField(r)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func writeStringsField(buf *bytes.Buffer, values []string) {
+	writeInt64Field(buf, int64(len(values)))
+	for _, v := range values {
+		writeStringField(buf, v)
+	}
+}
+
+func readStringsField(r *bytes.Reader) ([]string, error) {
+	n, err := readLength(r)
+	if err != nil {
+		return nil, err
+	}
+	if n == 0 {
+		return nil, nil
+	}
+	values := make([]string, n)
+	for i := range values {
+		if values[i], err = readStringField(r); err != nil {
+			return nil, err
+		}
+	}
+	return values, nil
+}
+
+func writeStringMapField(buf *bytes.Buffer, m map[string]string) {
+	writeInt64Field(buf, int64(len(m)))
+	for k, v := range m {
+		writeStringField(buf, k)
+		writeStringField(buf, v)
+	}
+}
+
+func readStringMapField(r *bytes.Reader) (map[string]string, error) {
+	n, err := readLength(r)
+	if err != nil {
+		return nil, err
+	}
+	if n == 0 {
+		return nil, nil
+	}
+	m := make(map[string]string, n)
+	for i := int64(0); i < n; i++ {
+		k, err := readStringField(r)
+		if err != nil {
+			return nil, err
+		}
+		v, err := readStringField(r)
+		if err != nil {
+			return nil, err
+		}
+		m[k] = v
+	}
+	return m, nil
+}
+
+func writeHeaderField(buf *bytes.Buffer, h http.Header) {
+	writeInt64Field(buf, int64(len(h)))
+	for k, values := range h {
+		writeStringField(buf, k)
+		writeStringsField(buf, values)
+	}
+}
+
+func readHeaderField(r *bytes.Reader) (http.Header, error) {
+	n, err := readLength(r)
+	if err != nil {
+		return nil, err
+	}
+	if n == 0 {
+		return nil, nil
+	}
+	h := make(http.Header, n)
+	for i := int64(0); i < n; i++ {
+		k, err := readStringField(r)
+		if err != nil {
+			return nil, err
+		}
+		values, err := readStringsField(r)
+		if err != nil {
+			return nil, err
+		}
+		h[k] = values
+	}
+	return h, nil
+}
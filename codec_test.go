@@ -0,0 +1,106 @@
+package cache
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+// benchmarkResponse builds a Response representative of a typical cached
+// JSON API response: a few KB body, a handful of headers, and the Vary
+// metadata RFC7234 mode attaches.
+func benchmarkResponse() Response {
+	now := time.Now().UTC().Truncate(time.Second)
+	return Response{
+		Value: []byte(`{"id":1,"name":"widget","tags":["a","b","c"],` +
+			`"description":"` + string(make([]byte, 2048)) + `"}`),
+		Header: http.Header{
+			"Content-Type":   {"application/json"},
+			"Content-Length": {"2048"},
+			"ETag":           {`"abc123"`},
+		},
+		Expiration: now.Add(time.Minute),
+		LastAccess: now,
+		Frequency:  3,
+		Date:       now,
+		Vary:       []string{"Accept-Encoding"},
+		VaryValues: map[string]string{"Accept-Encoding": "gzip"},
+		ETag:       `"abc123"`,
+	}
+}
+
+func TestCodecsRoundTrip(t *testing.T) {
+	codecs := map[string]Codec{
+		"Gob":    GobCodec{},
+		"JSON":   JSONCodec{},
+		"Binary": BinaryCodec{},
+	}
+	want := benchmarkResponse()
+
+	for name, codec := range codecs {
+		t.Run(name, func(t *testing.T) {
+			b, err := codec.Marshal(want)
+			if err != nil {
+				t.Fatalf("Marshal() error = %v", err)
+			}
+			got, err := codec.Unmarshal(b)
+			if err != nil {
+				t.Fatalf("Unmarshal() error = %v", err)
+			}
+			if string(got.Value) != string(want.Value) {
+				t.Errorf("Value = %q, want %q", got.Value, want.Value)
+			}
+			if got.ETag != want.ETag {
+				t.Errorf("ETag = %q, want %q", got.ETag, want.ETag)
+			}
+			if got.VaryValues["Accept-Encoding"] != "gzip" {
+				t.Errorf("VaryValues[Accept-Encoding] = %q, want gzip", got.VaryValues["Accept-Encoding"])
+			}
+		})
+	}
+}
+
+func TestBinaryCodecRejectsCorruptLength(t *testing.T) {
+	b, err := BinaryCodec{}.Marshal(benchmarkResponse())
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+	// Overwrite the leading length-prefixed Value field with a length
+	// larger than the remaining buffer, simulating a corrupt entry.
+	for i := 0; i < 8; i++ {
+		b[i] = 0xff
+	}
+	if _, err := (BinaryCodec{}).Unmarshal(b); err == nil {
+		t.Error("Unmarshal() error = nil, want error for corrupt length prefix")
+	}
+}
+
+func benchmarkCodec(b *testing.B, codec Codec) {
+	response := benchmarkResponse()
+	encoded, err := codec.Marshal(response)
+	if err != nil {
+		b.Fatalf("Marshal() error = %v", err)
+	}
+
+	b.Run("Marshal", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := codec.Marshal(response); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("Unmarshal", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := codec.Unmarshal(encoded); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.ReportMetric(float64(len(encoded)), "bytes/entry")
+}
+
+func BenchmarkGobCodec(b *testing.B)    { benchmarkCodec(b, GobCodec{}) }
+func BenchmarkJSONCodec(b *testing.B)   { benchmarkCodec(b, JSONCodec{}) }
+func BenchmarkBinaryCodec(b *testing.B) { benchmarkCodec(b, BinaryCodec{}) }
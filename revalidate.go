@@ -0,0 +1,63 @@
+package cache
+
+import "net/http"
+
+// conditionalRecorder buffers a response produced by a conditional
+// revalidation request, so Middleware can decide whether to keep the
+// cached entry (304) or replace it before anything reaches the real
+// http.ResponseWriter.
+type conditionalRecorder struct {
+	header     http.Header
+	statusCode int
+	body       []byte
+}
+
+func newConditionalRecorder() *conditionalRecorder {
+	return &conditionalRecorder{header: http.Header{}, statusCode: http.StatusOK}
+}
+
+func (w *conditionalRecorder) Header() http.Header { return w.header }
+
+func (w *conditionalRecorder) WriteHeader(statusCode int) { w.statusCode = statusCode }
+
+func (w *conditionalRecorder) Write(b []byte) (int, error) {
+	w.body = append(w.body, b...)
+	return len(b), nil
+}
+
+// revalidate forwards a conditional copy of r to next, carrying the stored
+// response's validators, and reports whether the origin confirmed the
+// entry is still current with a 304 Not Modified.
+func (c *Client) revalidate(next http.Handler, r *http.Request, response Response) (*conditionalRecorder, bool) {
+	rec := newConditionalRecorder()
+	next.ServeHTTP(rec, withValidators(r, response))
+	return rec, rec.statusCode == http.StatusNotModified
+}
+
+// withValidators clones r and injects If-None-Match / If-Modified-Since
+// derived from a stale cached response's validators.
+func withValidators(r *http.Request, response Response) *http.Request {
+	clone := r.Clone(r.Context())
+	if response.ETag != "" {
+		clone.Header.Set("If-None-Match", response.ETag)
+	}
+	if response.LastModified != "" {
+		clone.Header.Set("If-Modified-Since", response.LastModified)
+	}
+	return clone
+}
+
+// notModified reports whether the incoming request's validators already
+// match the cached response, meaning it can be answered with a 304 without
+// involving next at all.
+func notModified(response Response, r *http.Request) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		return response.ETag != "" && inm == response.ETag
+	}
+	if ims := r.Header.Get("If-Modified-Since"); ims != "" && response.LastModified != "" {
+		since, errSince := http.ParseTime(ims)
+		lastMod, errLastMod := http.ParseTime(response.LastModified)
+		return errSince == nil && errLastMod == nil && !lastMod.After(since)
+	}
+	return false
+}
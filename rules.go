@@ -0,0 +1,134 @@
+package cache
+
+import (
+	"net/http"
+	"path"
+	"regexp"
+)
+
+// Rule decides whether a request/response pair is eligible for caching.
+// Implementations only need to evaluate what they care about: a rule that
+// only cares about the request can leave MatchResponse returning true, and
+// vice versa. Rules are evaluated in two phases by Client.Middleware:
+// MatchRequest gates whether a lookup/store is attempted at all, and
+// MatchResponse gates whether a freshly produced response is persisted.
+type Rule interface {
+	// MatchRequest reports whether r may be looked up or stored at all.
+	// Evaluated before next is invoked.
+	MatchRequest(r *http.Request) bool
+
+	// MatchResponse reports whether a freshly produced response may be
+	// persisted. Evaluated after next has run.
+	MatchResponse(statusCode int, header http.Header, body []byte) bool
+}
+
+// ClientWithRules restricts caching to requests and responses accepted by
+// every given Rule. Optional setting. If not set, the only eligibility
+// check is the configured methods.
+func ClientWithRules(rules ...Rule) ClientOption {
+	return func(c *Client) error {
+		c.rules = rules
+		return nil
+	}
+}
+
+// pathRule matches a request's URL path against a shell glob pattern.
+type pathRule struct {
+	glob string
+}
+
+// PathRule builds a Rule that only allows requests whose URL path matches
+// the given shell glob pattern (see path.Match), e.g. "/api/*".
+func PathRule(glob string) Rule {
+	return pathRule{glob: glob}
+}
+
+func (rule pathRule) MatchRequest(r *http.Request) bool {
+	ok, err := path.Match(rule.glob, r.URL.Path)
+	return err == nil && ok
+}
+
+func (pathRule) MatchResponse(int, http.Header, []byte) bool { return true }
+
+// pathRegexRule matches a request's URL path against a regular expression.
+type pathRegexRule struct {
+	re *regexp.Regexp
+}
+
+// PathRegexRule builds a Rule that only allows requests whose URL path
+// matches the given regular expression.
+func PathRegexRule(re *regexp.Regexp) Rule {
+	return pathRegexRule{re: re}
+}
+
+func (rule pathRegexRule) MatchRequest(r *http.Request) bool {
+	return rule.re.MatchString(r.URL.Path)
+}
+
+func (pathRegexRule) MatchResponse(int, http.Header, []byte) bool { return true }
+
+// headerRule matches a response header value against a regular expression.
+type headerRule struct {
+	name       string
+	valueRegex *regexp.Regexp
+}
+
+// HeaderRule builds a Rule that only allows responses whose header named
+// name has a value matching valueRegex, e.g. HeaderRule("Content-Type",
+// regexp.MustCompile(`^application/json`)).
+func HeaderRule(name string, valueRegex *regexp.Regexp) Rule {
+	return headerRule{name: name, valueRegex: valueRegex}
+}
+
+func (headerRule) MatchRequest(*http.Request) bool { return true }
+
+func (rule headerRule) MatchResponse(_ int, header http.Header, _ []byte) bool {
+	return rule.valueRegex.MatchString(header.Get(rule.name))
+}
+
+// statusRule matches a response's status code against an inclusive range.
+type statusRule struct {
+	min, max int
+}
+
+// StatusRule builds a Rule that only allows responses whose status code
+// falls within [min, max].
+func StatusRule(min, max int) Rule {
+	return statusRule{min: min, max: max}
+}
+
+func (statusRule) MatchRequest(*http.Request) bool { return true }
+
+func (rule statusRule) MatchResponse(statusCode int, _ http.Header, _ []byte) bool {
+	return statusCode >= rule.min && statusCode <= rule.max
+}
+
+// hasStatusRule reports whether rules includes a StatusRule, i.e. whether
+// something has taken explicit control over which status codes are
+// storable.
+func hasStatusRule(rules []Rule) bool {
+	for _, rule := range rules {
+		if _, ok := rule.(statusRule); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// maxBodyBytesRule matches a response whose body does not exceed a size
+// limit.
+type maxBodyBytesRule struct {
+	n int64
+}
+
+// MaxBodyBytesRule builds a Rule that only allows responses whose body is
+// at most n bytes, so an oversized response isn't pushed into the adapter.
+func MaxBodyBytesRule(n int64) Rule {
+	return maxBodyBytesRule{n: n}
+}
+
+func (maxBodyBytesRule) MatchRequest(*http.Request) bool { return true }
+
+func (rule maxBodyBytesRule) MatchResponse(_ int, _ http.Header, body []byte) bool {
+	return int64(len(body)) <= rule.n
+}
@@ -0,0 +1,111 @@
+package cache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestPathRuleMatchRequest(t *testing.T) {
+	rule := PathRule("/api/*")
+
+	r, _ := http.NewRequest(http.MethodGet, "/api/widgets", nil)
+	if !rule.MatchRequest(r) {
+		t.Error("MatchRequest() = false, want true for a path matching the glob")
+	}
+
+	r, _ = http.NewRequest(http.MethodGet, "/other/widgets", nil)
+	if rule.MatchRequest(r) {
+		t.Error("MatchRequest() = true, want false for a path outside the glob")
+	}
+}
+
+func TestPathRegexRuleMatchRequest(t *testing.T) {
+	rule := PathRegexRule(regexp.MustCompile(`^/api/v\d+/`))
+
+	r, _ := http.NewRequest(http.MethodGet, "/api/v2/widgets", nil)
+	if !rule.MatchRequest(r) {
+		t.Error("MatchRequest() = false, want true for a path matching the regex")
+	}
+
+	r, _ = http.NewRequest(http.MethodGet, "/api/widgets", nil)
+	if rule.MatchRequest(r) {
+		t.Error("MatchRequest() = true, want false for a path not matching the regex")
+	}
+}
+
+func TestHeaderRuleMatchResponse(t *testing.T) {
+	rule := HeaderRule("Content-Type", regexp.MustCompile(`^application/json`))
+
+	header := http.Header{"Content-Type": {"application/json; charset=utf-8"}}
+	if !rule.MatchResponse(http.StatusOK, header, nil) {
+		t.Error("MatchResponse() = false, want true for a header value matching the regex")
+	}
+
+	header = http.Header{"Content-Type": {"text/html"}}
+	if rule.MatchResponse(http.StatusOK, header, nil) {
+		t.Error("MatchResponse() = true, want false for a header value not matching the regex")
+	}
+}
+
+func TestMaxBodyBytesRuleMatchResponse(t *testing.T) {
+	rule := MaxBodyBytesRule(4)
+
+	if !rule.MatchResponse(http.StatusOK, nil, []byte("ok")) {
+		t.Error("MatchResponse() = false, want true for a body within the limit")
+	}
+	if rule.MatchResponse(http.StatusOK, nil, []byte("too long")) {
+		t.Error("MatchResponse() = true, want false for a body over the limit")
+	}
+}
+
+// TestMiddlewareComposesRules checks that PathRule and MaxBodyBytesRule, a
+// pre-handler and a post-handler gate, compose: a request outside the path
+// never reaches the adapter, and a response over the body limit is served
+// but not stored.
+func TestMiddlewareComposesRules(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/big" {
+			w.Write([]byte("this body is over the limit"))
+			return
+		}
+		w.Write([]byte("ok"))
+	})
+
+	adapter := newMemoryAdapter()
+	client, err := NewClient(
+		ClientWithAdapter(adapter),
+		ClientWithTTL(time.Minute),
+		ClientWithRules(PathRule("/api/*"), MaxBodyBytesRule(4)),
+	)
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	mw := client.Middleware(handler)
+
+	r, _ := http.NewRequest(http.MethodGet, "/other", nil)
+	w := httptest.NewRecorder()
+	mw.ServeHTTP(w, r)
+	if _, ok := adapter.Get(client.hash(r)); ok {
+		t.Error("request outside PathRule's glob was cached")
+	}
+
+	r, _ = http.NewRequest(http.MethodGet, "/api/big", nil)
+	w = httptest.NewRecorder()
+	mw.ServeHTTP(w, r)
+	if w.Body.String() != "this body is over the limit" {
+		t.Errorf("body = %q, want the response served even though it wasn't cached", w.Body.String())
+	}
+	if _, ok := adapter.Get(client.hash(r)); ok {
+		t.Error("oversized response was cached despite MaxBodyBytesRule")
+	}
+
+	r, _ = http.NewRequest(http.MethodGet, "/api/widgets", nil)
+	w = httptest.NewRecorder()
+	mw.ServeHTTP(w, r)
+	if _, ok := adapter.Get(client.hash(r)); !ok {
+		t.Error("request satisfying both rules was not cached")
+	}
+}
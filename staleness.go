@@ -0,0 +1,40 @@
+package cache
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// staleWindows returns the stale-while-revalidate and stale-if-error grace
+// periods (RFC 5861) that apply to a response: its own Cache-Control
+// directives when present, otherwise the client's configured fallbacks.
+func (c *Client) staleWindows(header http.Header) (swr, sie time.Duration) {
+	swr, sie = c.staleWhileRevalidate, c.staleIfError
+	cc := parseCacheControl(header.Get("Cache-Control"))
+	if d, ok := cc.duration("stale-while-revalidate"); ok {
+		swr = d
+	}
+	if d, ok := cc.duration("stale-if-error"); ok {
+		sie = d
+	}
+	return swr, sie
+}
+
+// refreshStale re-invokes next in the background against a detached copy
+// of r, deduplicated per key so a burst of stale hits triggers at most one
+// in-flight refresh. baseKey is the Vary-agnostic key the caller already
+// computed, threaded through to store so it doesn't re-hash r. A response
+// that comes back is stored as the new entry; a server error leaves the
+// stale entry in place for stale-if-error to keep serving.
+func (c *Client) refreshStale(next http.Handler, r *http.Request, baseKey, key uint64) {
+	go c.refreshGroup.Do(KeyAsString(key), func() (interface{}, error) {
+		clone := r.Clone(context.Background())
+		rec := newConditionalRecorder()
+		next.ServeHTTP(rec, clone)
+		if rec.statusCode < 500 {
+			c.store(clone, baseKey, rec.header, rec.statusCode, rec.body)
+		}
+		return nil, nil
+	})
+}